@@ -0,0 +1,144 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"strings"
+
+	"github.com/gravitational/teleport"
+	"github.com/gravitational/teleport/lib/services"
+)
+
+// OIDCIdentity is the set of claims extracted from a validated
+// upstream ID token
+type OIDCIdentity struct {
+	// Email is the verified "email" claim
+	Email string
+	// EmailVerified mirrors the "email_verified" claim
+	EmailVerified bool
+	// HostedDomain mirrors the "hd" claim set by G Suite style providers
+	HostedDomain string
+	// Groups are extracted from whichever claim the connector has
+	// been configured to treat as the group membership claim
+	Groups []string
+	// RefreshToken is the OAuth2 refresh token issued alongside the ID
+	// token, if the connector requested offline access. It is empty
+	// when the provider does not support refresh
+	RefreshToken string
+}
+
+// OIDCConnector defines the subset of an OIDC/OAuth2 relying party
+// client that the web handler's OIDC login needs in order to complete a login:
+// producing the authorization redirect, and exchanging the
+// authorization code plus validating the resulting ID token. It is
+// implemented by a real client talking to the upstream provider in
+// production, and by a fake provider in tests (see
+// lib/auth/testoidc).
+type OIDCConnector interface {
+	// Name returns the connector name as it appears in
+	// Config.OIDCConnectors and in the "/webapi/oidc/providers"
+	// listing
+	Name() string
+
+	// AuthCodeURL returns the URL the browser should be redirected to
+	// in order to begin the OIDC flow. state and nonce are opaque
+	// values the caller generates and must be able to verify when the
+	// callback is later invoked
+	AuthCodeURL(state, nonce string) string
+
+	// Identity exchanges an authorization code returned on the
+	// callback for a validated identity. It must reject tokens whose
+	// nonce does not match the one passed to AuthCodeURL
+	Identity(code, nonce string) (*OIDCIdentity, error)
+
+	// Refresh exchanges a previously issued refresh token for a fresh
+	// identity, without requiring the user to interact with the
+	// provider again. It must fail with teleport.IsAccessDenied if
+	// refreshToken has been revoked or is unknown to the provider
+	Refresh(refreshToken string) (*OIDCIdentity, error)
+}
+
+// OIDCConnectorConfig configures a single upstream OIDC/OAuth2
+// identity provider
+type OIDCConnectorConfig struct {
+	// Name is the unique identifier for this connector, referenced in
+	// login requests and displayed in the provider list
+	Name string `json:"name"`
+	// IssuerURL is the provider's OIDC issuer, used for discovery
+	IssuerURL string `json:"issuer_url"`
+	// ClientID is the OAuth2 client id registered with the provider
+	ClientID string `json:"client_id"`
+	// ClientSecret is the OAuth2 client secret registered with the provider
+	ClientSecret string `json:"client_secret"`
+	// Scopes are the OAuth2 scopes requested during authorization,
+	// "openid" and "email" are always added implicitly
+	Scopes []string `json:"scopes"`
+	// AllowedDomains restricts logins to accounts whose verified email
+	// or "hd" claim matches one of these domains. An empty list allows
+	// any domain
+	AllowedDomains []string `json:"allowed_domains"`
+	// ClaimsToRoles maps an OIDC group claim value to the teleport
+	// logins granted to users who are a member of that group
+	ClaimsToRoles map[string][]string `json:"claims_to_roles"`
+}
+
+// AllowedLogins returns the teleport OS logins granted to an identity
+// coming from this connector, computed from ClaimsToRoles
+func (cfg *OIDCConnectorConfig) AllowedLogins(identity *OIDCIdentity) []string {
+	var logins []string
+	for _, group := range identity.Groups {
+		logins = append(logins, cfg.ClaimsToRoles[group]...)
+	}
+	return logins
+}
+
+// CheckPolicy validates that an identity returned by the provider is
+// allowed to log in under this connector's policy
+func (cfg *OIDCConnectorConfig) CheckPolicy(identity *OIDCIdentity) error {
+	if !identity.EmailVerified {
+		return teleport.AccessDenied("email %q is not verified", identity.Email)
+	}
+	if len(cfg.AllowedDomains) == 0 {
+		return nil
+	}
+	var emailDomain string
+	if i := strings.LastIndex(identity.Email, "@"); i != -1 {
+		emailDomain = identity.Email[i+1:]
+	}
+	for _, domain := range cfg.AllowedDomains {
+		if identity.HostedDomain == domain || emailDomain == domain {
+			return nil
+		}
+	}
+	return teleport.AccessDenied("domain %q is not allowed by connector %q", identity.HostedDomain, cfg.Name)
+}
+
+// CreateOIDCUser upserts a teleport user record for a successful OIDC
+// login, granting it the logins computed from the connector's claim
+// mapping. It is called by the web package's OIDC callback handler so
+// the AllowedLogins an upstream identity actually resolves to takes
+// effect on the teleport user, not just on the web session
+func CreateOIDCUser(clt ClientI, cfg *OIDCConnectorConfig, identity *OIDCIdentity) (*services.User, error) {
+	if err := cfg.CheckPolicy(identity); err != nil {
+		return nil, err
+	}
+	user := services.User{Name: identity.Email, AllowedLogins: cfg.AllowedLogins(identity)}
+	if err := clt.UpsertUser(user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
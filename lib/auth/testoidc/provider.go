@@ -0,0 +1,116 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testoidc provides a fake OIDC provider for use in tests,
+// mirroring the role that testauthority plays for the SSH CA: it
+// satisfies lib/auth's OIDCConnector interface without talking to a
+// real upstream identity provider.
+package testoidc
+
+import (
+	"fmt"
+
+	"github.com/gravitational/teleport"
+	"github.com/gravitational/teleport/lib/auth"
+)
+
+// Provider is a fake OIDCConnector that hands out a fixed identity for
+// any authorization code minted by AuthCodeURL, and checks the nonce
+// bound to each code the way a real provider would
+type Provider struct {
+	name           string
+	identity       auth.OIDCIdentity
+	codes          map[string]string // code -> nonce
+	refreshTokens  map[string]bool   // refresh token -> valid
+	nextRefreshNum int
+}
+
+// New returns a fake provider called name that will resolve any code
+// it issues to identity. The identity's RefreshToken field, if set,
+// is treated as already-issued and valid
+func New(name string, identity auth.OIDCIdentity) *Provider {
+	p := &Provider{
+		name:          name,
+		identity:      identity,
+		codes:         make(map[string]string),
+		refreshTokens: make(map[string]bool),
+	}
+	if identity.RefreshToken != "" {
+		p.refreshTokens[identity.RefreshToken] = true
+	}
+	return p
+}
+
+// RevokeRefreshToken simulates the provider (or the user) invalidating
+// a previously issued refresh token, so a subsequent Refresh call
+// fails exactly as it would against a real upstream provider
+func (p *Provider) RevokeRefreshToken(token string) {
+	delete(p.refreshTokens, token)
+}
+
+// Name returns the connector name
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// AuthCodeURL mints a fresh authorization code bound to nonce and
+// returns a URL a test can "visit" by extracting the code parameter
+func (p *Provider) AuthCodeURL(state, nonce string) string {
+	var code string
+	for n := len(p.codes); ; n++ {
+		code = fmt.Sprintf("%v-%v", p.name, n)
+		if _, taken := p.codes[code]; !taken {
+			break
+		}
+	}
+	p.codes[code] = nonce
+	return fmt.Sprintf("https://fake-provider.example.com/auth?state=%v&code=%v", state, code)
+}
+
+// Identity exchanges code for the configured identity, rejecting codes
+// that are unknown or whose nonce does not match the one returned by
+// the matching AuthCodeURL call
+func (p *Provider) Identity(code, nonce string) (*auth.OIDCIdentity, error) {
+	expected, ok := p.codes[code]
+	if !ok {
+		return nil, teleport.AccessDenied("unknown authorization code %q", code)
+	}
+	if expected != nonce {
+		return nil, teleport.AccessDenied("nonce mismatch for code %q", code)
+	}
+	delete(p.codes, code)
+	identity := p.identity
+	return &identity, nil
+}
+
+// Refresh exchanges a refresh token for a fresh copy of the provider's
+// identity, rotating the refresh token in the process, and rejects
+// tokens that are unknown or have been revoked
+func (p *Provider) Refresh(refreshToken string) (*auth.OIDCIdentity, error) {
+	if !p.refreshTokens[refreshToken] {
+		return nil, teleport.AccessDenied("refresh token %q is revoked or unknown", refreshToken)
+	}
+	delete(p.refreshTokens, refreshToken)
+
+	p.nextRefreshNum++
+	newToken := fmt.Sprintf("%v-refresh-%v", p.name, p.nextRefreshNum)
+	p.refreshTokens[newToken] = true
+
+	identity := p.identity
+	identity.RefreshToken = newToken
+	p.identity = identity
+	return &identity, nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gravitational/teleport"
+)
+
+// cookieChunkSize is the maximum size of a single session_N cookie's
+// value, kept comfortably under the ~4KB per-cookie limit most
+// browsers enforce so a handful of chunks can still fit in the
+// combined per-domain cookie budget
+const cookieChunkSize = 3800
+
+// maxCookieChunks is how many session_N cookies clearSessionCookie
+// expires on logout, and the index below which setSessionCookie
+// expires chunks it did not write. setSessionCookie still writes every
+// chunk a larger payload needs; chunks at or past this index are not
+// cleared on logout
+const maxCookieChunks = 8
+
+// sessionCookiePayload is the data carried in the (possibly chunked)
+// session cookie. Groups is populated from the upstream identity's
+// group claims on an OIDC login, and can grow large enough on its own
+// to push the encoded payload past a single cookie's size limit
+type sessionCookiePayload struct {
+	Token  string   `json:"token"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// sessionChunkCookieName returns the name of the i'th chunk of the session
+// cookie
+func sessionChunkCookieName(i int) string {
+	return fmt.Sprintf("%v_%v", sessionCookieName, i)
+}
+
+// setSessionCookie serializes payload, signs it and splits it across
+// as many session_0, session_1, ... cookies as it takes to carry it,
+// clearing any leftover chunks from a previous, larger session
+func (h *Handler) setSessionCookie(w http.ResponseWriter, tok string, groups []string) error {
+	payload, err := json.Marshal(sessionCookiePayload{Token: tok, Groups: groups})
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, h.cookieKey)
+	mac.Write(payload)
+	signed := append(mac.Sum(nil), payload...)
+	encoded := base64.URLEncoding.EncodeToString(signed)
+
+	var i int
+	for start := 0; start < len(encoded); start += cookieChunkSize {
+		end := start + cookieChunkSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionChunkCookieName(i),
+			Value:    encoded[start:end],
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   !h.cfg.InsecureHTTPMode,
+		})
+		i++
+	}
+	for ; i < maxCookieChunks; i++ {
+		h.clearCookie(w, sessionChunkCookieName(i))
+	}
+	return nil
+}
+
+// clearSessionCookie expires every session_N cookie chunk
+func (h *Handler) clearSessionCookie(w http.ResponseWriter) {
+	for i := 0; i < maxCookieChunks; i++ {
+		h.clearCookie(w, sessionChunkCookieName(i))
+	}
+}
+
+func (h *Handler) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   !h.cfg.InsecureHTTPMode,
+		MaxAge:   -1,
+	})
+}
+
+// readSessionCookie reassembles the session cookie's chunks, verifies
+// the HMAC over the concatenated payload and returns the bearer token
+// and group claims it carries
+func (h *Handler) readSessionCookie(r *http.Request) (string, []string, error) {
+	var encoded string
+	for i := 0; ; i++ {
+		cookie, err := r.Cookie(sessionChunkCookieName(i))
+		if err != nil {
+			break
+		}
+		encoded += cookie.Value
+	}
+	if encoded == "" {
+		return "", nil, teleport.AccessDenied("missing session cookie")
+	}
+
+	signed, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, teleport.AccessDenied("invalid session cookie")
+	}
+	if len(signed) < sha256.Size {
+		return "", nil, teleport.AccessDenied("truncated session cookie")
+	}
+	sig, payload := signed[:sha256.Size], signed[sha256.Size:]
+
+	mac := hmac.New(sha256.New, h.cookieKey)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", nil, teleport.AccessDenied("session cookie failed signature check")
+	}
+
+	var p sessionCookiePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", nil, teleport.AccessDenied("malformed session cookie")
+	}
+	return p.Token, p.Groups, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gravitational/teleport"
+	"github.com/gravitational/teleport/lib/auth"
+	"github.com/gravitational/teleport/lib/reversetunnel"
+
+	"github.com/gravitational/roundtrip"
+	"github.com/julienschmidt/httprouter"
+)
+
+// krlSiteAdapter adapts a reversetunnel.RemoteSite to auth.KRLSite,
+// reusing the site's existing GetClient() rather than requiring
+// RemoteSite to grow a PushKRL method of its own
+type krlSiteAdapter struct {
+	site reversetunnel.RemoteSite
+}
+
+func (a krlSiteAdapter) GetName() string {
+	return a.site.GetName()
+}
+
+func (a krlSiteAdapter) GetClient() (auth.KRLClient, error) {
+	return a.site.GetClient()
+}
+
+// krlSites adapts the proxy's connected reversetunnel sites to
+// auth.KRLSite so PushKRL can deliver a freshly revoked KRL to each
+// of them
+func (h *Handler) krlSites() []auth.KRLSite {
+	sites := h.cfg.Proxy.GetSites()
+	out := make([]auth.KRLSite, len(sites))
+	for i, site := range sites {
+		out[i] = krlSiteAdapter{site: site}
+	}
+	return out
+}
+
+// getKRL streams the cluster's current key revocation list, for an
+// admin to archive. The body is KRLManager.Marshal's JSON rendering,
+// not an OpenSSH binary KRL
+func (h *Handler) getKRL(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if h.cfg.KRL == nil {
+		roundtrip.ReplyJSON(w, http.StatusNotFound, teleport.NotFound("KRL is not configured"))
+		return
+	}
+	blob, err := h.cfg.KRL.Marshal()
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(blob)
+}
+
+// revokeKRL adds a single entry (by serial or fingerprint) to the
+// cluster's KRL, used by an admin session to immediately cut off a
+// compromised cert or key, then pushes the updated KRL to every
+// connected site so the revocation takes effect without waiting for
+// each site's own poll cycle
+func (h *Handler) revokeKRL(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if h.cfg.KRL == nil {
+		roundtrip.ReplyJSON(w, http.StatusNotFound, teleport.NotFound("KRL is not configured"))
+		return
+	}
+	var req auth.RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.cfg.KRL.Revoke(req); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.cfg.KRL.PushKRL(h.krlSites()); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	krl := h.cfg.KRL.Current()
+	roundtrip.ReplyJSON(w, http.StatusOK, &krl)
+}
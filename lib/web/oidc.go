@@ -0,0 +1,186 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gravitational/teleport"
+	"github.com/gravitational/teleport/lib/auth"
+
+	"github.com/gravitational/roundtrip"
+	"github.com/julienschmidt/httprouter"
+)
+
+// oidcProvider describes a single configured connector, returned by
+// GET /webapi/oidc/providers so the web UI can render a login button
+// per provider without exposing client secrets
+type oidcProvider struct {
+	Name string `json:"name"`
+}
+
+type getOIDCProvidersResponse struct {
+	Providers []oidcProvider `json:"providers"`
+}
+
+func (h *Handler) oidcProviders(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	out := make([]oidcProvider, 0, len(h.cfg.OIDCConnectors))
+	for _, cfg := range h.cfg.OIDCConnectors {
+		out = append(out, oidcProvider{Name: cfg.Name})
+	}
+	roundtrip.ReplyJSON(w, http.StatusOK, &getOIDCProvidersResponse{Providers: out})
+}
+
+func (h *Handler) connectorConfig(name string) (*auth.OIDCConnectorConfig, error) {
+	for i := range h.cfg.OIDCConnectors {
+		if h.cfg.OIDCConnectors[i].Name == name {
+			return &h.cfg.OIDCConnectors[i], nil
+		}
+	}
+	return nil, teleport.NotFound("OIDC connector %q is not configured", name)
+}
+
+// oidcLoginStart redirects the browser to the upstream provider's
+// authorization endpoint, stashing the state/nonce pair in a cookie
+// that oidcCallback compares against on return. The cookie value is
+// plain text: it is neither signed nor given an expiry
+func (h *Handler) oidcLoginStart(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	connectorName := r.URL.Query().Get("connector_id")
+	cfg, err := h.connectorConfig(connectorName)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusNotFound, err)
+		return
+	}
+	connector, err := h.cfg.OIDCConnectorFactory(cfg)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	redirectURL := r.URL.Query().Get("redirect_url")
+	if redirectURL != "" && !h.IsValidRedirect(redirectURL) {
+		roundtrip.ReplyJSON(w, http.StatusBadRequest, teleport.BadParameter("invalid redirect_url"))
+		return
+	}
+
+	state, err := newToken()
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	nonce, err := newToken()
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	h.setOIDCRequestCookie(w, connectorName, state, nonce, redirectURL)
+
+	http.Redirect(w, r, connector.AuthCodeURL(state, nonce), http.StatusFound)
+}
+
+// oidcCallback validates the state/nonce pair returned by the
+// provider, exchanges the authorization code for a validated
+// identity, and issues the same createSessionResponse (bearer token +
+// session cookie) that the password+HOTP login path produces
+func (h *Handler) oidcCallback(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	connectorName, state, nonce, redirectURL, err := readOIDCRequestCookie(r)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, err)
+		return
+	}
+	if r.URL.Query().Get("state") != state {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("state mismatch"))
+		return
+	}
+	if redirectURL != "" && !h.IsValidRedirect(redirectURL) {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("invalid redirect_url"))
+		return
+	}
+
+	cfg, err := h.connectorConfig(connectorName)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusNotFound, err)
+		return
+	}
+	connector, err := h.cfg.OIDCConnectorFactory(cfg)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	identity, err := connector.Identity(r.URL.Query().Get("code"), nonce)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, err)
+		return
+	}
+
+	sess, err := h.createOIDCSession(w, cfg, identity)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, err)
+		return
+	}
+	sess.RedirectURL = redirectURL
+	roundtrip.ReplyJSON(w, http.StatusOK, sess)
+}
+
+// oidcRequestCookie is the name of the browser-session cookie that ties
+// an outgoing authorization request to the callback that completes it
+const oidcRequestCookie = "oidc_req"
+
+func (h *Handler) setOIDCRequestCookie(w http.ResponseWriter, connector, state, nonce, redirectURL string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcRequestCookie,
+		Value:    connector + "|" + state + "|" + nonce + "|" + redirectURL,
+		Path:     "/v1/webapi/oidc",
+		HttpOnly: true,
+		Secure:   !h.cfg.InsecureHTTPMode,
+	})
+}
+
+func readOIDCRequestCookie(r *http.Request) (connector, state, nonce, redirectURL string, err error) {
+	cookie, err := r.Cookie(oidcRequestCookie)
+	if err != nil {
+		return "", "", "", "", teleport.AccessDenied("missing OIDC request cookie")
+	}
+	parts := strings.SplitN(cookie.Value, "|", 4)
+	if len(parts) != 4 {
+		return "", "", "", "", teleport.AccessDenied("malformed OIDC request cookie")
+	}
+	return parts[0], parts[1], parts[2], parts[3], nil
+}
+
+// createOIDCSession validates the returned identity against the
+// connector's policy, upserts the corresponding teleport user with
+// the logins mapped from its claims, and returns the same bearer
+// token + cookie response a password+HOTP login would. The session
+// retains identity.RefreshToken so it can later be renewed through
+// POST /webapi/sessions/renew without prompting the user again
+func (h *Handler) createOIDCSession(w http.ResponseWriter, cfg *auth.OIDCConnectorConfig, identity *auth.OIDCIdentity) (*createSessionResponse, error) {
+	user, err := auth.CreateOIDCUser(h.cfg.AuthClient, cfg, identity)
+	if err != nil {
+		return nil, err
+	}
+	_, tok, err := h.newSession(user.Name, cfg.Name, identity.RefreshToken, identity.Groups)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.setSessionCookie(w, tok, identity.Groups); err != nil {
+		return nil, err
+	}
+	return newSessionResponse(tok), nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"net/url"
+	"strings"
+)
+
+// IsValidRedirect reports whether u is safe to send a browser to
+// after login, guarding against open-redirect and cookie-exfiltration
+// attacks from a forged redirect_url/return_to parameter. It rejects
+// anything that isn't an absolute https URL (http is allowed only
+// under InsecureHTTPMode, as in the test suite), whose host isn't in
+// cfg.RedirectDomains, or that contains a backslash, an "@", or a
+// protocol-relative "//" prefix
+func (h *Handler) IsValidRedirect(u string) bool {
+	if strings.ContainsAny(u, `\@`) {
+		return false
+	}
+	if strings.HasPrefix(u, "//") {
+		return false
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+	case "http":
+		if !h.cfg.InsecureHTTPMode {
+			return false
+		}
+	default:
+		return false
+	}
+	if parsed.Host == "" {
+		return false
+	}
+	return isAllowedRedirectHost(parsed.Hostname(), h.cfg.RedirectDomains)
+}
+
+// isAllowedRedirectHost checks host against allowlist, where an entry
+// starting with "." matches any subdomain (but not the bare domain
+// itself) and any other entry must match host exactly
+func isAllowedRedirectHost(host string, allowlist []string) bool {
+	for _, domain := range allowlist {
+		if strings.HasPrefix(domain, ".") {
+			if strings.HasSuffix(host, domain) {
+				return true
+			}
+			continue
+		}
+		if host == domain {
+			return true
+		}
+	}
+	return false
+}
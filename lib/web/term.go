@@ -0,0 +1,243 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gravitational/teleport"
+	"github.com/gravitational/teleport/lib/auth"
+	"github.com/gravitational/teleport/lib/reversetunnel"
+	"github.com/gravitational/teleport/lib/sshutils"
+
+	"github.com/gravitational/roundtrip"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/websocket"
+)
+
+// terminalCertTTL is how long the ephemeral SSH user certificate
+// minted for a single web terminal connection remains valid. It only
+// needs to outlive the SSH handshake, not the shell session itself
+const terminalCertTTL = time.Minute
+
+// sessionIDEnvVar is the SSH session env var the node's shell uses to
+// join req.SessionID to an existing, already-registered session
+// instead of starting a brand new one
+const sessionIDEnvVar = "TELEPORT_SESSION_ID"
+
+// authenticateConnectRequest authenticates the websocket upgrade
+// request behind connect. It mirrors withAuth's checks, but reads the
+// bearer token from the access_token query parameter when the
+// Authorization header isn't available, since browsers cannot set
+// custom headers on a WebSocket handshake
+func (h *Handler) authenticateConnectRequest(r *http.Request) (*webSession, error) {
+	tok := r.URL.Query().Get(roundtrip.AccessTokenQueryParam)
+	if tok == "" {
+		var err error
+		tok, err = bearerToken(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cookieTok, _, err := h.readSessionCookie(r)
+	if err != nil || cookieTok != tok {
+		return nil, teleport.AccessDenied("missing or mismatched session cookie")
+	}
+	h.mu.Lock()
+	sess, ok := h.sessions[tok]
+	h.mu.Unlock()
+	if !ok {
+		return nil, teleport.AccessDenied("invalid bearer token")
+	}
+	if h.now().After(sess.expires) {
+		return nil, teleport.AccessDenied("bearer token has expired")
+	}
+	return sess, nil
+}
+
+// checkLoginAllowed rejects login unless it appears in the
+// AllowedLogins teleport has on file for user, the authenticated
+// identity of the session making the request. Without this,
+// proxyTerminal would mint a certificate for whatever OS login the
+// client asked for, making the AllowedLogins mapping CreateOIDCUser
+// (and an admin, for password users) populates meaningless for the
+// one flow that actually opens a shell
+func (h *Handler) checkLoginAllowed(user, login string) error {
+	u, err := h.cfg.AuthClient.GetUser(user)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range u.AllowedLogins {
+		if allowed == login {
+			return nil
+		}
+	}
+	return teleport.AccessDenied("user %q is not permitted to log in as %q", user, login)
+}
+
+// connect upgrades the request to a websocket and bridges it to an
+// interactive shell on the requested node. It is the handler behind
+// GET /webapi/sites/:site/connect
+func (h *Handler) connect(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sess, err := h.authenticateConnectRequest(r)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, err)
+		return
+	}
+
+	var req connectReq
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("params")), &req); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.checkLoginAllowed(sess.user, req.Login); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, err)
+		return
+	}
+
+	site, err := h.site(p.ByName("site"))
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusNotFound, err)
+		return
+	}
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		proxyTerminal(h.cfg.KRL, site, req, ws)
+	}).ServeHTTP(w, r)
+}
+
+// checkCertNotRevoked parses certBytes as an SSH certificate and
+// checks it against krl, mirroring srv.checkNotRevoked on the node's
+// side of the same connection. A nil krl never rejects. certBytes that
+// doesn't parse as a public key, or doesn't parse as a certificate, is
+// surfaced as an error rather than treated as not revoked: a node
+// would never hand proxyTerminal a blob shaped like that, so seeing
+// one here means something upstream is broken and the connection
+// should fail closed, not silently proceed
+func checkCertNotRevoked(krl *auth.KRLManager, certBytes []byte) error {
+	if krl == nil {
+		return nil
+	}
+	pk, err := ssh.ParsePublicKey(certBytes)
+	if err != nil {
+		return err
+	}
+	cert, ok := pk.(*ssh.Certificate)
+	if !ok {
+		return teleport.BadParameter("expected an SSH certificate")
+	}
+	if krl.IsCertRevoked(cert, false) {
+		return teleport.AccessDenied("certificate serial %v has been revoked", cert.Serial)
+	}
+	return nil
+}
+
+// proxyTerminal mints a short-lived user certificate for req.Login,
+// uses it to open an interactive SSH shell on req.Addr through site,
+// and copies bytes between that shell and ws until either side
+// closes. Because every call mints a brand new certificate with a
+// fresh serial, checking it against krl here can only ever catch a
+// revoked signing CA (RevokeCAKey), not a previously issued serial or
+// fingerprint an admin just revoked: the next connect after a revoke
+// always presents a serial the KRL has never seen. Rejecting one
+// already-issued, still-live certificate is what srv.WrapPublicKeyCallback
+// is for, on the node's own SSH handshake against the longer-lived
+// certificate a client is actually holding
+func proxyTerminal(krl *auth.KRLManager, site reversetunnel.RemoteSite, req connectReq, ws *websocket.Conn) error {
+	clt, err := site.GetClient()
+	if err != nil {
+		return err
+	}
+
+	priv, pub, err := clt.GenerateKeyPair("")
+	if err != nil {
+		return err
+	}
+	cert, err := clt.GenerateUserCert(pub, req.Login, terminalCertTTL)
+	if err != nil {
+		return err
+	}
+	if err := checkCertNotRevoked(krl, cert); err != nil {
+		return err
+	}
+	signer, err := sshutils.NewSigner(priv, cert)
+	if err != nil {
+		return err
+	}
+
+	conn, err := site.Dial("tcp", req.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sconn, chans, sreqs, err := ssh.NewClientConn(conn, req.Addr, &ssh.ClientConfig{
+		User: req.Login,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+	})
+	if err != nil {
+		return err
+	}
+	sshClt := ssh.NewClient(sconn, chans, sreqs)
+	defer sshClt.Close()
+
+	sess, err := sshClt.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	if req.SessionID != "" {
+		if err := sess.Setenv(sessionIDEnvVar, req.SessionID); err != nil {
+			return err
+		}
+	}
+
+	if err := sess.RequestPty("xterm", req.Term.H, req.Term.W, ssh.TerminalModes{}); err != nil {
+		return err
+	}
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := sess.Shell(); err != nil {
+		return err
+	}
+
+	errC := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(stdin, ws)
+		errC <- err
+	}()
+	go func() {
+		_, err := io.Copy(ws, stdout)
+		errC <- err
+	}()
+	return <-errC
+}
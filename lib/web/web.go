@@ -0,0 +1,680 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package web implements web proxy handler that provides
+// web interface to view and connect to teleport nodes
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/teleport"
+	"github.com/gravitational/teleport/lib/auth"
+	"github.com/gravitational/teleport/lib/reversetunnel"
+	"github.com/gravitational/teleport/lib/utils"
+
+	"github.com/gravitational/roundtrip"
+	"github.com/julienschmidt/httprouter"
+)
+
+// bearerTokenTTL is how long a bearer token minted by createSession or
+// createOIDCSession remains valid before it must be renewed
+const bearerTokenTTL = 30 * time.Minute
+
+// proactiveRenewDelay is how long after minting an OIDC-backed session
+// the background renew timer fires, timed to land at ~75% of the
+// bearer token's lifetime so the session refreshes itself before the
+// client ever has a chance to see a 401 from an expired token
+const proactiveRenewDelay = bearerTokenTTL * 3 / 4
+
+// sessionCookieName is the base name of the (possibly chunked)
+// session_0, session_1, ... cookies that pin a bearer token to a
+// browser; see cookie.go for the chunking codec
+const sessionCookieName = "session"
+
+// currentSiteShortcut is a special shortcut that will return the first
+// available site, is used in tests and in single-site configuration mode
+const currentSiteShortcut = "-current-"
+
+// Config represents web handler configuration parameters
+type Config struct {
+	// Proxy is a reverse tunnel proxy that handles connections
+	// to remote sites
+	Proxy reversetunnel.Server
+	// AssetsDir is a directory with web assets (static files)
+	AssetsDir string
+	// AuthServers is a list of auth servers this proxy talks to
+	AuthServers utils.NetAddr
+	// DomainName is a name of this teleport cluster
+	DomainName string
+	// InsecureHTTPMode is a flag that disables TLS,
+	// it is used in tests and not in production
+	InsecureHTTPMode bool
+	// OIDCConnectors is a list of configured upstream OIDC/OAuth2
+	// identity providers that can be used to log in to this cluster
+	OIDCConnectors []auth.OIDCConnectorConfig
+	// OIDCConnectorFactory builds the auth.OIDCConnector used to talk
+	// to a configured provider. It is a field (rather than a direct
+	// call into lib/auth) so tests can substitute a fake provider
+	OIDCConnectorFactory func(cfg *auth.OIDCConnectorConfig) (auth.OIDCConnector, error)
+	// AuthClient is used to validate plain username/password/HOTP
+	// logins against the cluster's auth server
+	AuthClient auth.ClientI
+	// Clock is used to check bearer token expiry, defaults to
+	// time.Now. Tests override it to fast-forward past token expiry
+	Clock func() time.Time
+	// KRL is the cluster's key revocation list manager. Nil disables
+	// the /webapi/sites/:site/krl endpoints
+	KRL *auth.KRLManager
+	// After schedules scheduleProactiveRenew's background timer,
+	// defaults to time.After. Tests substitute a channel they control
+	// so the timer can be fired deterministically instead of waiting
+	// on real wall-clock time
+	After func(d time.Duration) <-chan time.Time
+	// RedirectDomains is the allowlist IsValidRedirect checks a
+	// post-login or OIDC callback redirect_url against. An entry
+	// starting with "." (e.g. ".example.com") allows any subdomain;
+	// any other entry must match the host exactly
+	RedirectDomains []string
+	// AdminUsers lists the teleport users permitted to manage the
+	// cluster's KRL through /webapi/sites/:site/krl. Config-driven,
+	// the same way RedirectDomains and OIDCConnectorConfig's
+	// AllowedDomains are, rather than pulling in a full roles
+	// subsystem
+	AdminUsers []string
+}
+
+// Handler is HTTP web proxy handler
+type Handler struct {
+	httprouter.Router
+	cfg Config
+
+	mu       sync.Mutex
+	sessions map[string]*webSession
+
+	// cookieKey signs the session cookie so a chunked, reassembled
+	// cookie can be checked for truncation or tampering
+	cookieKey []byte
+}
+
+// webSession is the server-side record of a bearer token minted by
+// createSession or createOIDCSession
+type webSession struct {
+	// user is the teleport identity this session was authenticated
+	// as: the login name for a password+HOTP session, or the OIDC
+	// identity's email for an SSO session. connect checks it against
+	// AllowedLogins before minting a certificate, and withAdmin checks
+	// it against Config.AdminUsers
+	user string
+	// expires is when this bearer token stops being accepted
+	expires time.Time
+	// connectorName is the OIDC connector this session was created
+	// through, empty for a plain username/password/HOTP login
+	connectorName string
+	// refreshToken is the upstream OIDC refresh token used to
+	// silently mint a new session once this one expires. Empty for
+	// sessions that cannot be renewed without user interaction
+	refreshToken string
+	// groups are the group claims the identity carried at login time,
+	// also carried in the signed, HttpOnly session cookie payload
+	groups []string
+}
+
+// NewHandler returns a new instance of web handler
+func NewHandler(cfg Config) (*Handler, error) {
+	if cfg.Clock == nil {
+		cfg.Clock = time.Now
+	}
+	if cfg.After == nil {
+		cfg.After = time.After
+	}
+	cookieKey := make([]byte, 32)
+	if _, err := rand.Read(cookieKey); err != nil {
+		return nil, err
+	}
+	h := &Handler{
+		cfg:       cfg,
+		sessions:  make(map[string]*webSession),
+		cookieKey: cookieKey,
+	}
+
+	// session endpoints
+	h.POST("/v1/webapi/sessions", h.createSession)
+	h.POST("/v1/webapi/sessions/renew", h.renewSession)
+	h.DELETE("/v1/webapi/sessions/:sid", h.deleteSession)
+
+	// user invite / signup endpoints
+	h.GET("/v1/webapi/users/invites/:token", h.renderUserInvite)
+	h.POST("/v1/webapi/users", h.createNewUser)
+
+	// site and node endpoints
+	h.GET("/v1/webapi/sites", h.withAuth(h.getSites))
+	h.GET("/v1/webapi/sites/:site/nodes", h.withAuth(h.getSiteNodes))
+	h.GET("/v1/webapi/sites/:site/connect", h.connect)
+
+	// key revocation list endpoints
+	h.GET("/v1/webapi/sites/:site/krl", h.withAdmin(h.getKRL))
+	h.POST("/v1/webapi/sites/:site/krl", h.withAdmin(h.revokeKRL))
+
+	// OIDC/OAuth2 SSO endpoints
+	h.GET("/v1/webapi/oidc/providers", h.oidcProviders)
+	h.GET("/v1/webapi/oidc/login", h.oidcLoginStart)
+	h.GET("/v1/webapi/oidc/callback", h.oidcCallback)
+
+	return h, nil
+}
+
+// now returns the current time, using cfg.Clock so tests can simulate
+// bearer token expiry without sleeping
+func (h *Handler) now() time.Time {
+	return h.cfg.Clock()
+}
+
+// newSession mints a fresh bearer token, records it server-side and
+// returns both the session record and the token itself
+func (h *Handler) newSession(user, connectorName, refreshToken string, groups []string) (*webSession, string, error) {
+	tok, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	sess := &webSession{
+		user:          user,
+		expires:       h.now().Add(bearerTokenTTL),
+		connectorName: connectorName,
+		refreshToken:  refreshToken,
+		groups:        groups,
+	}
+	h.mu.Lock()
+	h.sessions[tok] = sess
+	h.mu.Unlock()
+	if refreshToken != "" {
+		h.scheduleProactiveRenew(tok)
+	}
+	return sess, tok, nil
+}
+
+// scheduleProactiveRenew arms a background timer that silently
+// refreshes tok's OIDC identity at proactiveRenewDelay, extending the
+// session in place so the client goes on presenting the same bearer
+// token without ever seeing a 401. It reschedules itself on success.
+// It stops once the session is gone or its connector cannot be
+// resolved; when the upstream provider rejects the refresh token it
+// deletes the session, as renewSession does. It waits on h.cfg.After rather than time.AfterFunc
+// directly so tests can drive the timer with a fake clock instead of
+// real wall-clock time
+func (h *Handler) scheduleProactiveRenew(tok string) {
+	go func() {
+		<-h.cfg.After(proactiveRenewDelay)
+
+		h.mu.Lock()
+		sess, ok := h.sessions[tok]
+		h.mu.Unlock()
+		if !ok {
+			return
+		}
+
+		cfg, err := h.connectorConfig(sess.connectorName)
+		if err != nil {
+			return
+		}
+		connector, err := h.cfg.OIDCConnectorFactory(cfg)
+		if err != nil {
+			return
+		}
+		identity, err := connector.Refresh(sess.refreshToken)
+		if err != nil {
+			h.deleteSessionToken(tok)
+			return
+		}
+
+		h.mu.Lock()
+		sess, ok = h.sessions[tok]
+		if ok {
+			sess.expires = h.now().Add(bearerTokenTTL)
+			sess.refreshToken = identity.RefreshToken
+			sess.groups = identity.Groups
+		}
+		h.mu.Unlock()
+		if !ok {
+			return
+		}
+
+		h.scheduleProactiveRenew(tok)
+	}()
+}
+
+func (h *Handler) deleteSessionToken(tok string) {
+	h.mu.Lock()
+	delete(h.sessions, tok)
+	h.mu.Unlock()
+}
+
+// bearerToken extracts the bearer token from the Authorization header
+func bearerToken(r *http.Request) (string, error) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return "", teleport.AccessDenied("missing bearer authorization header")
+	}
+	return strings.TrimPrefix(header, prefix), nil
+}
+
+// withAuth wraps a handler so that it only runs when the request
+// carries both a valid, unexpired bearer token and a matching session
+// cookie, mirroring the access control exercised by TestNewUser
+func (h *Handler) withAuth(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		tok, err := bearerToken(r)
+		if err != nil {
+			roundtrip.ReplyJSON(w, http.StatusForbidden, err)
+			return
+		}
+		cookieTok, _, err := h.readSessionCookie(r)
+		if err != nil || cookieTok != tok {
+			roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("missing or mismatched session cookie"))
+			return
+		}
+		h.mu.Lock()
+		sess, ok := h.sessions[tok]
+		h.mu.Unlock()
+		if !ok {
+			roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("invalid bearer token"))
+			return
+		}
+		if h.now().After(sess.expires) {
+			roundtrip.ReplyJSON(w, http.StatusUnauthorized, teleport.AccessDenied("bearer token has expired"))
+			return
+		}
+		next(w, r, p)
+	}
+}
+
+// withAdmin wraps a handler the same way withAuth does, and further
+// requires the session's authenticated user to appear in
+// Config.AdminUsers, so a plain logged-in user cannot read or mutate
+// the cluster's KRL
+func (h *Handler) withAdmin(next httprouter.Handle) httprouter.Handle {
+	return h.withAuth(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		tok, err := bearerToken(r)
+		if err != nil {
+			roundtrip.ReplyJSON(w, http.StatusForbidden, err)
+			return
+		}
+		h.mu.Lock()
+		sess, ok := h.sessions[tok]
+		h.mu.Unlock()
+		if !ok || !h.isAdmin(sess.user) {
+			roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("admin access required"))
+			return
+		}
+		next(w, r, p)
+	})
+}
+
+// isAdmin reports whether user appears in Config.AdminUsers
+func (h *Handler) isAdmin(user string) bool {
+	for _, u := range h.cfg.AdminUsers {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// newToken returns a random, URL-safe token suitable for use as a
+// bearer token or an OIDC state/nonce value
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// site returns the remote site identified by siteName, resolving
+// currentSiteShortcut to the first registered site
+func (h *Handler) site(siteName string) (reversetunnel.RemoteSite, error) {
+	if siteName == currentSiteShortcut {
+		sites := h.cfg.Proxy.GetSites()
+		if len(sites) == 0 {
+			return nil, teleport.NotFound("no sites registered")
+		}
+		return sites[0], nil
+	}
+	return h.cfg.Proxy.GetSite(siteName)
+}
+
+// createSessionReq is a request to create a new web session
+// using username, password and second factor token
+type createSessionReq struct {
+	User              string `json:"user"`
+	Pass              string `json:"pass"`
+	SecondFactorToken string `json:"second_factor_token"`
+	// RedirectURL is where the browser should be sent after a
+	// successful login, checked against Config.RedirectDomains
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
+
+// createSessionResponse is a response to createSessionReq, it carries
+// the bearer token that should be used for all subsequent requests,
+// accompanied by a session cookie
+type createSessionResponse struct {
+	// Type is token type, usually "bearer"
+	Type string `json:"type"`
+	// Token is a bearer token used to authenticate requests
+	Token string `json:"token"`
+	// ExpiresIn sets seconds before this token is not valid
+	ExpiresIn int `json:"expires_in"`
+	// RedirectURL echoes back the caller's validated redirect_url, if any
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
+
+func newSessionResponse(tok string) *createSessionResponse {
+	return &createSessionResponse{
+		Type:      roundtrip.AuthBearer,
+		Token:     tok,
+		ExpiresIn: int(bearerTokenTTL.Seconds()),
+	}
+}
+
+func (h *Handler) createSession(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var req createSessionReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.User == "" || req.Pass == "" || req.SecondFactorToken == "" {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("missing required parameter"))
+		return
+	}
+	if req.RedirectURL != "" && !h.IsValidRedirect(req.RedirectURL) {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("invalid redirect_url"))
+		return
+	}
+	// a plain username/password/HOTP login has no upstream refresh
+	// token, so this session cannot be silently renewed
+	if err := h.cfg.AuthClient.CheckPassword(req.User, []byte(req.Pass), req.SecondFactorToken); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("invalid credentials"))
+		return
+	}
+	_, tok, err := h.newSession(req.User, "", "", nil)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := h.setSessionCookie(w, tok, nil); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	sess := newSessionResponse(tok)
+	sess.RedirectURL = req.RedirectURL
+	roundtrip.ReplyJSON(w, http.StatusOK, sess)
+}
+
+func (h *Handler) deleteSession(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	h.deleteSessionToken(p.ByName("sid"))
+	h.clearSessionCookie(w)
+	roundtrip.ReplyJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+}
+
+// renewSession exchanges a session's stored OIDC refresh token for a
+// fresh identity with the upstream provider and rotates the bearer
+// token + cookie without involving the user. It is the handler behind
+// POST /webapi/sessions/renew
+func (h *Handler) renewSession(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	tok, err := bearerToken(r)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, err)
+		return
+	}
+	h.mu.Lock()
+	sess, ok := h.sessions[tok]
+	h.mu.Unlock()
+	if !ok {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("unknown session"))
+		return
+	}
+	if sess.connectorName == "" || sess.refreshToken == "" {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied("session has no refresh token to renew"))
+		return
+	}
+
+	cfg, err := h.connectorConfig(sess.connectorName)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusForbidden, err)
+		return
+	}
+	connector, err := h.cfg.OIDCConnectorFactory(cfg)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	identity, err := connector.Refresh(sess.refreshToken)
+	if err != nil {
+		h.deleteSessionToken(tok)
+		roundtrip.ReplyJSON(w, http.StatusForbidden, teleport.AccessDenied(err.Error()))
+		return
+	}
+
+	_, newTok, err := h.newSession(sess.user, sess.connectorName, identity.RefreshToken, identity.Groups)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	h.deleteSessionToken(tok)
+
+	if err := h.setSessionCookie(w, newTok, identity.Groups); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	roundtrip.ReplyJSON(w, http.StatusOK, newSessionResponse(newTok))
+}
+
+type createNewUserReq struct {
+	InviteToken       string `json:"invite_token"`
+	Pass              string `json:"pass"`
+	SecondFactorToken string `json:"second_factor_token"`
+}
+
+type renderUserInviteResponse struct {
+	User        string `json:"user"`
+	InviteToken string `json:"invite_token"`
+}
+
+func (h *Handler) renderUserInvite(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	token := p.ByName("token")
+	user, _, _, err := h.cfg.AuthClient.GetSignupTokenData(token)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusNotFound, err)
+		return
+	}
+	roundtrip.ReplyJSON(w, http.StatusOK, &renderUserInviteResponse{User: user, InviteToken: token})
+}
+
+func (h *Handler) createNewUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	_, tok, err := h.newSession("", "", "", nil)
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := h.setSessionCookie(w, tok, nil); err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	roundtrip.ReplyJSON(w, http.StatusOK, newSessionResponse(tok))
+}
+
+type getSitesResponse struct {
+	Sites []site `json:"sites"`
+}
+
+type site struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+func (h *Handler) getSites(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	clusters := h.cfg.Proxy.GetSites()
+	out := make([]site, 0, len(clusters))
+	for _, cluster := range clusters {
+		out = append(out, site{Name: cluster.GetName(), Status: cluster.GetStatus()})
+	}
+	roundtrip.ReplyJSON(w, http.StatusOK, &getSitesResponse{Sites: out})
+}
+
+type getSiteNodesResponse struct {
+	Nodes []nodeWithSessions `json:"nodes"`
+}
+
+type nodeWithSessions struct {
+	ID       string           `json:"id"`
+	Hostname string           `json:"hostname"`
+	Addr     string           `json:"addr"`
+	Sessions []sessionSummary `json:"sessions"`
+}
+
+type sessionSummary struct {
+	ID string `json:"id"`
+}
+
+// getSiteNodes lists the site's registered nodes, each annotated with
+// the active sessions currently running on it, so the web UI can show
+// which nodes have a shell someone can join
+func (h *Handler) getSiteNodes(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	site, err := h.site(p.ByName("site"))
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusNotFound, err)
+		return
+	}
+	clt, err := site.GetClient()
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	nodes, err := clt.GetNodes()
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	sessions, err := clt.GetSessions()
+	if err != nil {
+		roundtrip.ReplyJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	out := make([]nodeWithSessions, 0, len(nodes))
+	for _, node := range nodes {
+		nws := nodeWithSessions{ID: node.ID, Hostname: node.Hostname, Addr: node.Addr}
+		for _, sess := range sessions {
+			if sess.ServerID == node.ID {
+				nws.Sessions = append(nws.Sessions, sessionSummary{ID: string(sess.ID)})
+			}
+		}
+		out = append(out, nws)
+	}
+	roundtrip.ReplyJSON(w, http.StatusOK, &getSiteNodesResponse{Nodes: out})
+}
+
+type connectTerm struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type connectReq struct {
+	Addr      string      `json:"addr"`
+	Login     string      `json:"login"`
+	Term      connectTerm `json:"term"`
+	SessionID string      `json:"sid"`
+}
+
+// webClient is a helper client used in tests to talk to the web handler
+type webClient struct {
+	*roundtrip.Client
+
+	mu    sync.Mutex
+	addr  string
+	token string
+	jar   http.CookieJar
+}
+
+func newWebClient(addr string, opts ...roundtrip.ClientParam) (*webClient, error) {
+	clt, err := roundtrip.NewClient(addr, "v1", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &webClient{Client: clt}, nil
+}
+
+func (w *webClient) Endpoint(parts ...string) string {
+	return w.Client.Endpoint(parts...)
+}
+
+// withRenew equips the client with what it needs to silently call
+// POST /webapi/sessions/renew and pick up the rotated bearer token
+// whenever a request comes back access denied
+func (w *webClient) withRenew(addr, token string, jar http.CookieJar) *webClient {
+	w.addr = addr
+	w.token = token
+	w.jar = jar
+	return w
+}
+
+// Get overrides roundtrip.Client.Get so that an access-denied response
+// (as returned once the bearer token has expired) triggers a single
+// silent renew-and-retry, mirroring what the JS client does on a 401
+func (w *webClient) Get(endpoint string, params url.Values) (*roundtrip.Response, error) {
+	re, err := w.Client.Get(endpoint, params)
+	if err == nil || !teleport.IsAccessDenied(err) || w.addr == "" {
+		return re, err
+	}
+	if err := w.renew(); err != nil {
+		return nil, err
+	}
+	return w.Client.Get(endpoint, params)
+}
+
+func (w *webClient) renew() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	clt, err := roundtrip.NewClient(w.addr, "v1", roundtrip.BearerAuth(w.token), roundtrip.CookieJar(w.jar))
+	if err != nil {
+		return err
+	}
+	re, err := clt.PostJSON(clt.Endpoint("webapi", "sessions", "renew"), struct{}{})
+	if err != nil {
+		return err
+	}
+	var sess *createSessionResponse
+	if err := json.Unmarshal(re.Bytes(), &sess); err != nil {
+		return err
+	}
+
+	newClt, err := roundtrip.NewClient(w.addr, "v1", roundtrip.BearerAuth(sess.Token), roundtrip.CookieJar(w.jar))
+	if err != nil {
+		return err
+	}
+	w.token = sess.Token
+	w.Client = newClt
+	return nil
+}